@@ -0,0 +1,59 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import "strings"
+
+// ParseRuleFields parses an iptables-save-style rule string into a Rule
+// with its structured fields (Protocol, Source, Destination, Target,
+// ...) and Comment populated. Packets/Bytes are left zero; callers set
+// them from whatever counter source they have (save output, netlink
+// stats, nft JSON).
+//
+// Shared by SaveBackend, NativeBackend and the nftables package so the
+// three backends agree on what "-p tcp --dport 22" means.
+func ParseRuleFields(ruleText string) Rule {
+	rule := Rule{Rule: ruleText, Comment: parseComment(ruleText)}
+
+	fields := strings.Fields(ruleText)
+	for i, field := range fields {
+		switch field {
+		case "-p", "--protocol":
+			rule.Protocol = fieldAt(fields, i+1)
+		case "-s", "--source":
+			rule.Source = fieldAt(fields, i+1)
+		case "-d", "--destination":
+			rule.Destination = fieldAt(fields, i+1)
+		case "-i", "--in-interface":
+			rule.InInterface = fieldAt(fields, i+1)
+		case "-o", "--out-interface":
+			rule.OutInterface = fieldAt(fields, i+1)
+		case "--sport", "--source-port":
+			rule.SPort = fieldAt(fields, i+1)
+		case "--dport", "--destination-port":
+			rule.DPort = fieldAt(fields, i+1)
+		case "-j", "--jump":
+			rule.Target = fieldAt(fields, i+1)
+		}
+	}
+	return rule
+}
+
+func fieldAt(fields []string, i int) string {
+	if i < 0 || i >= len(fields) {
+		return ""
+	}
+	return fields[i]
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import "testing"
+
+func TestParseRuleFields(t *testing.T) {
+	cases := []struct {
+		name     string
+		ruleText string
+		want     Rule
+	}{
+		{
+			name:     "tcp dport accept",
+			ruleText: `-A INPUT -p tcp -m tcp --dport 22 -j ACCEPT`,
+			want: Rule{
+				Protocol: "tcp",
+				DPort:    "22",
+				Target:   "ACCEPT",
+			},
+		},
+		{
+			name:     "source and destination",
+			ruleText: `-A FORWARD -s 10.0.0.1/32 -d 10.0.0.2/32 -j DROP`,
+			want: Rule{
+				Source:      "10.0.0.1/32",
+				Destination: "10.0.0.2/32",
+				Target:      "DROP",
+			},
+		},
+		{
+			name:     "long-form flags and interfaces",
+			ruleText: `-A INPUT --protocol udp --in-interface eth0 --out-interface eth1 --source-port 53 --jump ACCEPT`,
+			want: Rule{
+				Protocol:     "udp",
+				InInterface:  "eth0",
+				OutInterface: "eth1",
+				SPort:        "53",
+				Target:       "ACCEPT",
+			},
+		},
+		{
+			name:     "comment is parsed alongside fields",
+			ruleText: `-A INPUT -p tcp --dport 443 -m comment --comment "allow https" -j ACCEPT`,
+			want: Rule{
+				Protocol: "tcp",
+				DPort:    "443",
+				Target:   "ACCEPT",
+				Comment:  "allow https",
+			},
+		},
+		{
+			name:     "no recognized flags",
+			ruleText: `-A INPUT -j RETURN`,
+			want: Rule{
+				Target: "RETURN",
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := ParseRuleFields(tc.ruleText)
+			tc.want.Rule = tc.ruleText
+			if got != tc.want {
+				t.Errorf("ParseRuleFields(%q) = %+v, want %+v", tc.ruleText, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	cases := []struct {
+		ruleText string
+		want     string
+	}{
+		{`-A INPUT -m comment --comment "allow https" -j ACCEPT`, "allow https"},
+		{`-A INPUT -m comment --comment 'single quoted' -j ACCEPT`, "single quoted"},
+		{`-A INPUT -m comment --comment unquoted -j ACCEPT`, "unquoted"},
+		{`-A INPUT -j ACCEPT`, ""},
+	}
+
+	for _, tc := range cases {
+		if got := parseComment(tc.ruleText); got != tc.want {
+			t.Errorf("parseComment(%q) = %q, want %q", tc.ruleText, got, tc.want)
+		}
+	}
+}
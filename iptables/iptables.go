@@ -0,0 +1,60 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package iptables collects firewall table/chain/rule counters from the
+// local host so they can be exported as Prometheus metrics.
+package iptables
+
+// Rule is a single rule within a chain, along with its packet/byte
+// counters. Rule always carries the raw rule text; the remaining fields
+// are parsed out of it on a best-effort basis (an option iptables-save
+// never printed, e.g. because the match wasn't present, is left "").
+type Rule struct {
+	Rule    string
+	Comment string
+
+	Protocol     string
+	Source       string
+	Destination  string
+	InInterface  string
+	OutInterface string
+	SPort        string
+	DPort        string
+	Target       string
+
+	Packets uint64
+	Bytes   uint64
+}
+
+// Chain is a chain within a table: its default policy, the counters for
+// that policy, and the rules it contains in order.
+type Chain struct {
+	Policy  string
+	Packets uint64
+	Bytes   uint64
+	Rules   []Rule
+}
+
+// Table maps chain name to Chain.
+type Table map[string]Chain
+
+// Tables maps table name to Table.
+type Tables map[string]Table
+
+// Backend collects Tables from some source: a forked iptables-save
+// process, a netlink-backed native client, etc.
+type Backend interface {
+	// GetTables returns the current table/chain/rule state.
+	GetTables() (Tables, error)
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"io"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReaderBackend collects Tables by calling a caller-supplied function that
+// produces an io.Reader over iptables-save-style output, then parsing it.
+// Unlike SaveBackend it has no opinion about where that reader comes
+// from: a local exec.Command (as SaveBackend itself could be built on),
+// an SSH session via the sshrunner package, or anything else that can
+// hand back a reader.
+type ReaderBackend struct {
+	source func() (io.Reader, error)
+
+	fetchDuration prometheus.Histogram
+	fetchErrors   prometheus.Counter
+}
+
+// NewReaderBackend returns a Backend that calls source on every
+// GetTables() and parses its output as iptables-save/ip6tables-save -c
+// output. label is used as a ConstLabel on the backend's internal stats
+// (e.g. a probe target name); reg may be nil to skip registration.
+func NewReaderBackend(label string, source func() (io.Reader, error), reg prometheus.Registerer) *ReaderBackend {
+	b := &ReaderBackend{
+		source: source,
+		fetchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "iptables_probe_fetch_duration_seconds",
+			Help:        "iptables_exporter: Duration of fetching and parsing a probed target's iptables state.",
+			ConstLabels: prometheus.Labels{"target": label},
+		}),
+		fetchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "iptables_probe_fetch_errors_total",
+			Help:        "iptables_exporter: Number of times fetching a probed target's iptables state failed.",
+			ConstLabels: prometheus.Labels{"target": label},
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(b.fetchDuration, b.fetchErrors)
+	}
+	return b
+}
+
+// GetTables implements Backend.
+func (b *ReaderBackend) GetTables() (Tables, error) {
+	start := time.Now()
+	r, err := b.source()
+	if err != nil {
+		b.fetchErrors.Inc()
+		return nil, err
+	}
+	tables, err := parseSave(r)
+	b.fetchDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		b.fetchErrors.Inc()
+		return nil, err
+	}
+	return tables, nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// commentRE matches the `-m comment --comment "..."` match extension,
+// with or without quotes.
+var commentRE = regexp.MustCompile(`--comment\s+(?:"([^"]*)"|'([^']*)'|(\S+))`)
+
+// parseComment extracts the value of a `-m comment --comment "..."` match
+// from a rule string, if present.
+func parseComment(ruleText string) string {
+	m := commentRE.FindStringSubmatch(ruleText)
+	if m == nil {
+		return ""
+	}
+	for _, group := range m[1:] {
+		if group != "" {
+			return group
+		}
+	}
+	return ""
+}
+
+// SaveBackend collects Tables by forking an `iptables-save`/`ip6tables-save`
+// style command and parsing its `-c` (counters) output. This is the
+// original collection strategy; it is simple and portable but forks a
+// process and takes the xtables lock on every call.
+type SaveBackend struct {
+	command string
+	args    []string
+
+	forkDuration prometheus.Histogram
+	forkErrors   prometheus.Counter
+}
+
+// NewSaveBackend returns a Backend that runs command with args and parses
+// its stdout as iptables-save/ip6tables-save output. Internal stats (fork
+// duration, fork errors) are registered with reg, which may be nil to skip
+// registration.
+func NewSaveBackend(command string, args []string, reg prometheus.Registerer) *SaveBackend {
+	b := &SaveBackend{
+		command: command,
+		args:    args,
+		forkDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "iptables_save_fork_duration_seconds",
+			Help:        "iptables_exporter: Duration of forking and waiting for the save command.",
+			ConstLabels: prometheus.Labels{"command": command},
+		}),
+		forkErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "iptables_save_fork_errors_total",
+			Help:        "iptables_exporter: Number of times running the save command failed.",
+			ConstLabels: prometheus.Labels{"command": command},
+		}),
+	}
+	if reg != nil {
+		reg.MustRegister(b.forkDuration, b.forkErrors)
+	}
+	return b
+}
+
+// GetTables implements Backend.
+func (b *SaveBackend) GetTables() (Tables, error) {
+	start := time.Now()
+	cmd := exec.Command(b.command, b.args...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		b.forkErrors.Inc()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		b.forkErrors.Inc()
+		return nil, err
+	}
+	tables, parseErr := parseSave(out)
+	waitErr := cmd.Wait()
+	b.forkDuration.Observe(time.Since(start).Seconds())
+	if waitErr != nil {
+		b.forkErrors.Inc()
+		return nil, waitErr
+	}
+	if parseErr != nil {
+		b.forkErrors.Inc()
+		return nil, parseErr
+	}
+	return tables, nil
+}
+
+// GetTables runs command (with args) and parses its output as
+// iptables-save/ip6tables-save -c output. It is kept for callers that want
+// a one-shot collection without constructing a SaveBackend.
+func GetTables(command string, args ...string) (Tables, error) {
+	return NewSaveBackend(command, args, nil).GetTables()
+}
+
+// parseSave parses the `-c` (counters) output of iptables-save/ip6tables-save.
+func parseSave(r io.Reader) (Tables, error) {
+	tables := make(Tables)
+	var tableName string
+	var table Table
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"):
+			continue
+		case line == "COMMIT":
+			if tableName != "" {
+				tables[tableName] = table
+			}
+			tableName = ""
+			table = nil
+		case strings.HasPrefix(line, "*"):
+			tableName = strings.TrimPrefix(line, "*")
+			table = make(Table)
+		case strings.HasPrefix(line, ":"):
+			chainName, policy, packets, bytes, err := parseChainHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			table[chainName] = Chain{Policy: policy, Packets: packets, Bytes: bytes}
+		case strings.HasPrefix(line, "["):
+			packets, bytes, chainName, ruleText, err := parseRuleLine(line)
+			if err != nil {
+				return nil, err
+			}
+			rule := ParseRuleFields(ruleText)
+			rule.Packets = packets
+			rule.Bytes = bytes
+			chain := table[chainName]
+			chain.Rules = append(chain.Rules, rule)
+			table[chainName] = chain
+		default:
+			// -A CHAIN ... without counters; shouldn't happen with -c, but
+			// ignore rather than error out.
+		}
+	}
+	return tables, scanner.Err()
+}
+
+// parseChainHeader parses a line like:
+//
+//	:INPUT ACCEPT [123:456]
+func parseChainHeader(line string) (chainName, policy string, packets, bytes uint64, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return "", "", 0, 0, fmt.Errorf("iptables: malformed chain header %q", line)
+	}
+	chainName = strings.TrimPrefix(fields[0], ":")
+	policy = fields[1]
+	packets, bytes, err = parseCounters(fields[2])
+	return
+}
+
+// parseRuleLine parses a line like:
+//
+//	[123:456] -A INPUT -p tcp -m tcp --dport 22 -j ACCEPT
+func parseRuleLine(line string) (packets, bytes uint64, chainName, ruleText string, err error) {
+	fields := strings.SplitN(line, " ", 2)
+	if len(fields) != 2 {
+		return 0, 0, "", "", fmt.Errorf("iptables: malformed rule line %q", line)
+	}
+	packets, bytes, err = parseCounters(fields[0])
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+	ruleText = strings.TrimSpace(fields[1])
+	ruleFields := strings.Fields(ruleText)
+	if len(ruleFields) < 2 || ruleFields[0] != "-A" {
+		return 0, 0, "", "", fmt.Errorf("iptables: malformed rule line %q", line)
+	}
+	chainName = ruleFields[1]
+	return packets, bytes, chainName, ruleText, nil
+}
+
+// parseCounters parses the "[packets:bytes]" counter tuple.
+func parseCounters(s string) (packets, bytes uint64, err error) {
+	s = strings.TrimPrefix(s, "[")
+	s = strings.TrimSuffix(s, "]")
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("iptables: malformed counters %q", s)
+	}
+	packets, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	bytes, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return packets, bytes, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iptables
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleSave = `# Generated by iptables-save v1.8.4 on Sat Jan  1 00:00:00 2022
+*filter
+:INPUT ACCEPT [100:2000]
+:FORWARD DROP [0:0]
+:OUTPUT ACCEPT [300:4000]
+[50:600] -A INPUT -p tcp -m tcp --dport 22 -m comment --comment "ssh" -j ACCEPT
+[1:84] -A INPUT -p icmp -j DROP
+COMMIT
+# Completed on Sat Jan  1 00:00:00 2022
+`
+
+func TestParseSave(t *testing.T) {
+	tables, err := parseSave(strings.NewReader(sampleSave))
+	if err != nil {
+		t.Fatalf("parseSave: %v", err)
+	}
+
+	filter, ok := tables["filter"]
+	if !ok {
+		t.Fatalf("tables = %+v, want a \"filter\" table", tables)
+	}
+
+	input, ok := filter["INPUT"]
+	if !ok {
+		t.Fatalf("filter = %+v, want an \"INPUT\" chain", filter)
+	}
+	if input.Policy != "ACCEPT" || input.Packets != 100 || input.Bytes != 2000 {
+		t.Errorf("INPUT chain = %+v, want policy ACCEPT [100:2000]", input)
+	}
+	if len(input.Rules) != 2 {
+		t.Fatalf("INPUT.Rules = %+v, want 2 rules", input.Rules)
+	}
+
+	first := input.Rules[0]
+	if first.Protocol != "tcp" || first.DPort != "22" || first.Target != "ACCEPT" || first.Comment != "ssh" {
+		t.Errorf("first rule = %+v, want tcp/22/ACCEPT with comment %q", first, "ssh")
+	}
+	if first.Packets != 50 || first.Bytes != 600 {
+		t.Errorf("first rule counters = %d:%d, want 50:600", first.Packets, first.Bytes)
+	}
+
+	forward := filter["FORWARD"]
+	if forward.Policy != "DROP" {
+		t.Errorf("FORWARD.Policy = %q, want DROP", forward.Policy)
+	}
+}
+
+func TestParseChainHeader(t *testing.T) {
+	chainName, policy, packets, bytes, err := parseChainHeader(":INPUT ACCEPT [123:456]")
+	if err != nil {
+		t.Fatalf("parseChainHeader: %v", err)
+	}
+	if chainName != "INPUT" || policy != "ACCEPT" || packets != 123 || bytes != 456 {
+		t.Errorf("parseChainHeader = (%q, %q, %d, %d), want (INPUT, ACCEPT, 123, 456)", chainName, policy, packets, bytes)
+	}
+
+	if _, _, _, _, err := parseChainHeader(":INPUT"); err == nil {
+		t.Error("parseChainHeader(\":INPUT\") = nil error, want an error for a malformed header")
+	}
+}
+
+func TestParseRuleLine(t *testing.T) {
+	packets, bytes, chainName, ruleText, err := parseRuleLine(`[50:600] -A INPUT -p tcp --dport 22 -j ACCEPT`)
+	if err != nil {
+		t.Fatalf("parseRuleLine: %v", err)
+	}
+	if packets != 50 || bytes != 600 || chainName != "INPUT" || ruleText != "-A INPUT -p tcp --dport 22 -j ACCEPT" {
+		t.Errorf("parseRuleLine = (%d, %d, %q, %q), want (50, 600, INPUT, ...)", packets, bytes, chainName, ruleText)
+	}
+
+	if _, _, _, _, err := parseRuleLine(`not a rule line`); err == nil {
+		t.Error("parseRuleLine(\"not a rule line\") = nil error, want an error for a malformed line")
+	}
+}
+
+func TestParseCounters(t *testing.T) {
+	packets, bytes, err := parseCounters("[123:456]")
+	if err != nil {
+		t.Fatalf("parseCounters: %v", err)
+	}
+	if packets != 123 || bytes != 456 {
+		t.Errorf("parseCounters = (%d, %d), want (123, 456)", packets, bytes)
+	}
+
+	if _, _, err := parseCounters("[not-a-number:456]"); err == nil {
+		t.Error("parseCounters(\"[not-a-number:456]\") = nil error, want an error")
+	}
+}
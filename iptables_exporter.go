@@ -15,8 +15,12 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -26,10 +30,80 @@ import (
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
 	"github.com/retailnext/iptables_exporter/iptables"
+	"github.com/retailnext/iptables_exporter/nftables"
+	"github.com/retailnext/iptables_exporter/sshrunner"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-type collector struct{}
+// protocolBackend pairs a Backend with the labels the collector attaches
+// to every metric it produces: "backend" distinguishes iptables/ip6tables/
+// nft collection strategies, while "command" keeps the historical value
+// (the binary that was run).
+type protocolBackend struct {
+	name    string
+	command string
+	backend iptables.Backend
+}
+
+type collector struct {
+	backends []protocolBackend
+
+	labelFrom       string
+	requireComment  bool
+	ruleBytesDesc   *prometheus.Desc
+	rulePacketsDesc *prometheus.Desc
+
+	// structuredLabels enables the additional iptables_rule_matched_*
+	// family, which trades the opaque "rule" label for parsed match fields
+	// (protocol, src, dst, dport, target) plus the comment. It's high
+	// cardinality on rulesets with many distinct 5-tuples, so it's
+	// opt-in via --rules.structured-labels rather than replacing the
+	// existing rule metrics.
+	structuredLabels bool
+}
+
+// newRuleDescs builds the rule-level Descs according to --rules.label-from:
+// the "rule" label carries the raw rule text, the "comment" label carries
+// the parsed `-m comment --comment` value, and rule_index disambiguates
+// same-comment rules within a chain when the comment label is in use.
+func newRuleDescs(labelFrom string) (bytesDesc, packetsDesc *prometheus.Desc) {
+	labels := []string{"command", "backend", "table", "chain"}
+	switch labelFrom {
+	case "comment":
+		labels = append(labels, "comment", "rule_index")
+	case "both":
+		labels = append(labels, "rule", "comment", "rule_index")
+	default:
+		labels = append(labels, "rule")
+	}
+	bytesDesc = prometheus.NewDesc(
+		"iptables_rule_bytes_total",
+		"iptables_exporter: Total bytes matching a rule.",
+		labels,
+		nil,
+	)
+	packetsDesc = prometheus.NewDesc(
+		"iptables_rule_packets_total",
+		"iptables_exporter: Total packets matching a rule.",
+		labels,
+		nil,
+	)
+	return bytesDesc, packetsDesc
+}
+
+// newBackend constructs the configured backend kind for one protocol.
+// command/args are only used by the "save" backend; protocol ("ipv4" or
+// "ipv6") is only used by the "native" backend.
+func newBackend(kind, protocol, command string, args []string, reg prometheus.Registerer) (iptables.Backend, error) {
+	switch kind {
+	case "save":
+		return iptables.NewSaveBackend(command, args, reg), nil
+	case "native":
+		return iptables.NewNativeBackend(protocol, reg)
+	default:
+		return nil, fmt.Errorf("iptables_exporter: unknown --iptables.backend %q", kind)
+	}
+}
 
 var (
 	scrapeDurationDesc = prometheus.NewDesc(
@@ -41,40 +115,46 @@ var (
 
 	scrapeSuccessDesc = prometheus.NewDesc(
 		"iptables_scrape_success",
-		"iptables_exporter: Whether scraping iptables succeeded.",
-		nil,
+		"iptables_exporter: Whether scraping a given backend succeeded.",
+		[]string{"backend"},
 		nil,
 	)
 
 	defaultBytesDesc = prometheus.NewDesc(
 		"iptables_default_bytes_total",
 		"iptables_exporter: Total bytes matching a chain's default policy.",
-		[]string{"command", "table", "chain", "policy"},
+		[]string{"command", "backend", "table", "chain", "policy"},
 		nil,
 	)
 
 	defaultPacketsDesc = prometheus.NewDesc(
 		"iptables_default_packets_total",
 		"iptables_exporter: Total packets matching a chain's default policy.",
-		[]string{"command", "table", "chain", "policy"},
+		[]string{"command", "backend", "table", "chain", "policy"},
 		nil,
 	)
 
-	ruleBytesDesc = prometheus.NewDesc(
-		"iptables_rule_bytes_total",
-		"iptables_exporter: Total bytes matching a rule.",
-		[]string{"command", "table", "chain", "rule"},
+	// structuredRuleLabels are used by both halves of the
+	// iptables_rule_matched_* family. Every one of these is a distinct
+	// value per rule on a busy host (especially "dst"/"dport"), so this
+	// family's cardinality scales with the size of the ruleset times the
+	// number of distinct match tuples, not just the rule count; leave it
+	// disabled unless you actually query on these fields.
+	structuredRuleLabels = []string{"command", "backend", "table", "chain", "target", "proto", "src", "dst", "dport", "comment"}
+
+	structuredRuleBytesDesc = prometheus.NewDesc(
+		"iptables_rule_matched_bytes_total",
+		"iptables_exporter: Total bytes matching a rule, labeled by parsed match fields instead of the opaque rule text. High cardinality; see --rules.structured-labels.",
+		structuredRuleLabels,
 		nil,
 	)
 
-	rulePacketsDesc = prometheus.NewDesc(
-		"iptables_rule_packets_total",
-		"iptables_exporter: Total packets matching a rule.",
-		[]string{"command", "table", "chain", "rule"},
+	structuredRulePacketsDesc = prometheus.NewDesc(
+		"iptables_rule_matched_packets_total",
+		"iptables_exporter: Total packets matching a rule, labeled by parsed match fields instead of the opaque rule text. High cardinality; see --rules.structured-labels.",
+		structuredRuleLabels,
 		nil,
 	)
-
-	commands [2]string
 )
 
 func (c *collector) Describe(descChan chan<- *prometheus.Desc) {
@@ -82,25 +162,91 @@ func (c *collector) Describe(descChan chan<- *prometheus.Desc) {
 	descChan <- scrapeSuccessDesc
 	descChan <- defaultBytesDesc
 	descChan <- defaultPacketsDesc
-	descChan <- ruleBytesDesc
-	descChan <- rulePacketsDesc
+	descChan <- c.ruleBytesDesc
+	descChan <- c.rulePacketsDesc
+	if c.structuredLabels {
+		descChan <- structuredRuleBytesDesc
+		descChan <- structuredRulePacketsDesc
+	}
+}
+
+// probeHandler returns an http.HandlerFunc implementing /probe?target=...,
+// modeled on blackbox_exporter's multi-target pattern: each request builds
+// a fresh collector and Registry scoped to that one target, rather than
+// exposing every configured target's metrics on every scrape.
+func probeHandler(pool *sshrunner.Pool, cfg *sshrunner.Config, labelFrom string, requireComment, structuredLabels bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		target := req.URL.Query().Get("target")
+		if target == "" {
+			http.Error(w, "target parameter is required", http.StatusBadRequest)
+			return
+		}
+		tc, ok := cfg.Targets[target]
+		if !ok {
+			http.Error(w, fmt.Sprintf("no ssh config for target %q", target), http.StatusNotFound)
+			return
+		}
+
+		reg := prometheus.NewRegistry()
+		ruleBytesDesc, rulePacketsDesc := newRuleDescs(labelFrom)
+		pc := &collector{
+			labelFrom:        labelFrom,
+			requireComment:   requireComment,
+			ruleBytesDesc:    ruleBytesDesc,
+			rulePacketsDesc:  rulePacketsDesc,
+			structuredLabels: structuredLabels,
+		}
+		for _, proto := range []struct {
+			name    string
+			command string
+		}{
+			{"iptables", tc.IptablesCommand},
+			{"ip6tables", tc.Ip6tablesCommand},
+		} {
+			if len(strings.TrimSpace(proto.command)) < 1 {
+				continue
+			}
+			command := proto.command
+			pc.backends = append(pc.backends, protocolBackend{
+				name:    proto.name,
+				command: strings.Fields(command)[0],
+				backend: iptables.NewReaderBackend(target+"/"+proto.name, func() (io.Reader, error) {
+					out, err := pool.Run(target, command)
+					if err != nil {
+						return nil, err
+					}
+					return bytes.NewReader(out), nil
+				}, reg),
+			})
+		}
+
+		reg.MustRegister(pc)
+		promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, req)
+	}
+}
+
+// ruleOrComment returns the rule's parsed comment, falling back to the raw
+// rule text when the rule has no comment (and --rules.require-comment has
+// not already dropped it).
+func ruleOrComment(rule iptables.Rule) string {
+	if rule.Comment != "" {
+		return rule.Comment
+	}
+	return rule.Rule
 }
 
 func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
 	start := time.Now()
 
-	for _, command := range commands {
-		_command, _ := shlex.Split(command)
-		command = strings.Trim(_command[0], " \t\r\n")
-		if len(command) < 1 {
-			continue
-		}
-		tables, err := iptables.GetTables(command, _command[1:]...)
+	for _, pb := range c.backends {
+		command := pb.command
+		tables, err := pb.backend.GetTables()
 		if err != nil {
-			metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0)
-			log.Error(err, _command)
-			return
+			metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 0, pb.name)
+			log.Error(err, command)
+			continue
 		}
+		metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1, pb.name)
 
 		for tableName, table := range tables {
 			for chainName, chain := range table {
@@ -109,6 +255,7 @@ func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
 					prometheus.CounterValue,
 					float64(chain.Packets),
 					command,
+					pb.name,
 					tableName,
 					chainName,
 					chain.Policy,
@@ -118,34 +265,65 @@ func (c *collector) Collect(metricChan chan<- prometheus.Metric) {
 					prometheus.CounterValue,
 					float64(chain.Bytes),
 					command,
+					pb.name,
 					tableName,
 					chainName,
 					chain.Policy,
 				)
-				for _, rule := range chain.Rules {
-					metricChan <- prometheus.MustNewConstMetric(
-						rulePacketsDesc,
-						prometheus.CounterValue,
-						float64(rule.Packets),
-						command,
-						tableName,
-						chainName,
-						rule.Rule,
-					)
-					metricChan <- prometheus.MustNewConstMetric(
-						ruleBytesDesc,
-						prometheus.CounterValue,
-						float64(rule.Bytes),
-						command,
-						tableName,
-						chainName,
-						rule.Rule,
-					)
+				for ruleIndex, rule := range chain.Rules {
+					// requireComment only gates the opaque rule/comment
+					// metric pair below; --rules.structured-labels is an
+					// independent feature and must keep emitting full
+					// protocol/port/target visibility even for rules
+					// without a comment.
+					if !(rule.Comment == "" && c.requireComment && c.labelFrom != "rule") {
+						labels := []string{command, pb.name, tableName, chainName}
+						switch c.labelFrom {
+						case "comment":
+							labels = append(labels, ruleOrComment(rule), strconv.Itoa(ruleIndex))
+						case "both":
+							labels = append(labels, rule.Rule, ruleOrComment(rule), strconv.Itoa(ruleIndex))
+						default:
+							labels = append(labels, rule.Rule)
+						}
+
+						metricChan <- prometheus.MustNewConstMetric(
+							c.rulePacketsDesc,
+							prometheus.CounterValue,
+							float64(rule.Packets),
+							labels...,
+						)
+						metricChan <- prometheus.MustNewConstMetric(
+							c.ruleBytesDesc,
+							prometheus.CounterValue,
+							float64(rule.Bytes),
+							labels...,
+						)
+					}
+
+					if c.structuredLabels {
+						structuredLabels := []string{
+							command, pb.name, tableName, chainName,
+							rule.Target, rule.Protocol, rule.Source, rule.Destination, rule.DPort,
+							rule.Comment,
+						}
+						metricChan <- prometheus.MustNewConstMetric(
+							structuredRulePacketsDesc,
+							prometheus.CounterValue,
+							float64(rule.Packets),
+							structuredLabels...,
+						)
+						metricChan <- prometheus.MustNewConstMetric(
+							structuredRuleBytesDesc,
+							prometheus.CounterValue,
+							float64(rule.Bytes),
+							structuredLabels...,
+						)
+					}
 				}
 			}
 		}
 	}
-	metricChan <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, 1)
 	duration := time.Since(start)
 	metricChan <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds())
 }
@@ -184,6 +362,42 @@ func main() {
 		).Default(
 			"ip6tables-save -c",
 		).String()
+		nftablesCommand = kingpin.Flag(
+			"nftables.command",
+			"Command to run to collect nftables state, in addition to --iptables.backend. (empty to skip) Known gap: nft has no default-policy hit counter, so iptables_default_{bytes,packets}_total are always 0 for nft-backed chains.",
+		).Default(
+			"nft -j list ruleset",
+		).String()
+		iptablesBackend = kingpin.Flag(
+			"iptables.backend",
+			"Backend used to collect iptables state: 'save' forks iptables-save/ip6tables-save, 'native' reads via netlink without forking. Known gap: 'native' cannot read default-policy counters, so iptables_default_{bytes,packets}_total are always 0 with policy=\"-\" under this backend.",
+		).Default(
+			"save",
+		).Enum("save", "native")
+		rulesLabelFrom = kingpin.Flag(
+			"rules.label-from",
+			"Where to source the 'rule'/'comment' labels from: 'rule' uses the raw rule text, 'comment' uses the parsed -m comment value, 'both' exposes both labels.",
+		).Default(
+			"rule",
+		).Enum("rule", "comment", "both")
+		rulesRequireComment = kingpin.Flag(
+			"rules.require-comment",
+			"When set, rules with no -m comment value are dropped from the iptables_rule_{bytes,packets}_total pair instead of falling back to the rule text (only applies when --rules.label-from is comment or both). Does not affect --rules.structured-labels, which always covers every rule.",
+		).Default(
+			"false",
+		).Bool()
+		sshConfigPath = kingpin.Flag(
+			"ssh.config",
+			"Path to a YAML file configuring remote targets for the /probe endpoint. (empty to disable /probe)",
+		).Default(
+			"",
+		).String()
+		rulesStructuredLabels = kingpin.Flag(
+			"rules.structured-labels",
+			"Also expose iptables_rule_matched_{bytes,packets}_total, labeled by parsed match fields (target, proto, src, dst, dport, comment) instead of the opaque rule text. Adds significant cardinality; off by default.",
+		).Default(
+			"false",
+		).Bool()
 	)
 
 	log.AddFlags(kingpin.CommandLine)
@@ -194,23 +408,60 @@ func main() {
 	log.Infoln("Starting iptables_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	var c collector
 	r := prometheus.NewRegistry()
-	r.MustRegister(&c)
 	if (*goCollector) {
 		r.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""))
 		r.MustRegister(prometheus.NewGoCollector())
 	}
 
-	commands[0] = *iptablesCommand
-	commands[1] = *ip6tablesCommand
-	if len(commands[0]) < 1 {
-		commands[0] = "''"
+	ruleBytesDesc, rulePacketsDesc := newRuleDescs(*rulesLabelFrom)
+	c := collector{
+		labelFrom:        *rulesLabelFrom,
+		requireComment:   *rulesRequireComment,
+		ruleBytesDesc:    ruleBytesDesc,
+		rulePacketsDesc:  rulePacketsDesc,
+		structuredLabels: *rulesStructuredLabels,
 	}
-	if len(commands[1]) < 1 {
-		commands[1] = "''"
+	for _, proto := range []struct {
+		name     string
+		command  string
+		protocol string
+	}{
+		{"iptables", *iptablesCommand, "ipv4"},
+		{"ip6tables", *ip6tablesCommand, "ipv6"},
+	} {
+		if len(strings.TrimSpace(proto.command)) < 1 {
+			continue
+		}
+		args, _ := shlex.Split(proto.command)
+		command := strings.Trim(args[0], " \t\r\n")
+		backend, err := newBackend(*iptablesBackend, proto.protocol, command, args[1:], r)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.backends = append(c.backends, protocolBackend{name: proto.name, command: command, backend: backend})
+	}
+	if len(strings.TrimSpace(*nftablesCommand)) > 0 {
+		args, _ := shlex.Split(*nftablesCommand)
+		command := strings.Trim(args[0], " \t\r\n")
+		c.backends = append(c.backends, protocolBackend{
+			name:    "nft",
+			command: command,
+			backend: nftables.NewBackend(command, args[1:], r),
+		})
+	}
+	r.MustRegister(&c)
+	log.Infoln("Backend:", *iptablesBackend)
+
+	if len(strings.TrimSpace(*sshConfigPath)) > 0 {
+		sshConfig, err := sshrunner.LoadConfig(*sshConfigPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pool := sshrunner.NewPool(sshConfig)
+		http.HandleFunc("/probe", probeHandler(pool, sshConfig, *rulesLabelFrom, *rulesRequireComment, *rulesStructuredLabels))
+		log.Infoln("Probing", len(sshConfig.Targets), "targets via /probe")
 	}
-	log.Infoln("Commands: ", commands)
 
 	http.Handle(*metricsPath, promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
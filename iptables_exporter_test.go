@@ -0,0 +1,175 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/retailnext/iptables_exporter/iptables"
+)
+
+// fakeBackend is a canned iptables.Backend for exercising collector.Collect
+// without forking a real iptables-save/nft/netlink call.
+type fakeBackend struct {
+	tables iptables.Tables
+}
+
+func (b *fakeBackend) GetTables() (iptables.Tables, error) {
+	return b.tables, nil
+}
+
+// commentedRule and uncommentedRule back every test below: one rule with a
+// -m comment value, one without, so label-from/require-comment/structured-
+// labels interactions can all be exercised against the same ruleset.
+var (
+	commentedRule = iptables.Rule{
+		Rule:     `-A INPUT -p tcp --dport 22 -m comment --comment "ssh"`,
+		Comment:  "ssh",
+		Protocol: "tcp",
+		DPort:    "22",
+		Target:   "ACCEPT",
+		Packets:  10,
+		Bytes:    100,
+	}
+	uncommentedRule = iptables.Rule{
+		Rule:     `-A INPUT -p tcp --dport 443`,
+		Protocol: "tcp",
+		DPort:    "443",
+		Target:   "ACCEPT",
+		Packets:  20,
+		Bytes:    200,
+	}
+)
+
+func newTestCollector(labelFrom string, requireComment, structuredLabels bool) (*collector, *fakeBackend) {
+	backend := &fakeBackend{
+		tables: iptables.Tables{
+			"filter": iptables.Table{
+				"INPUT": iptables.Chain{
+					Policy: "ACCEPT",
+					Rules:  []iptables.Rule{commentedRule, uncommentedRule},
+				},
+			},
+		},
+	}
+	ruleBytesDesc, rulePacketsDesc := newRuleDescs(labelFrom)
+	c := &collector{
+		labelFrom:        labelFrom,
+		requireComment:   requireComment,
+		ruleBytesDesc:    ruleBytesDesc,
+		rulePacketsDesc:  rulePacketsDesc,
+		structuredLabels: structuredLabels,
+		backends: []protocolBackend{
+			{name: "iptables", command: "iptables-save -c", backend: backend},
+		},
+	}
+	return c, backend
+}
+
+// gather registers c with a fresh Registry, scrapes it, and returns the
+// metric family with the given name (nil if absent).
+func gather(t *testing.T, c *collector, name string) *dto.MetricFamily {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return f
+		}
+	}
+	return nil
+}
+
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+func TestCollectLabelFromRule(t *testing.T) {
+	c, _ := newTestCollector("rule", false, false)
+	family := gather(t, c, "iptables_rule_packets_total")
+	if family == nil || len(family.GetMetric()) != 2 {
+		t.Fatalf("iptables_rule_packets_total metrics = %v, want 2", family)
+	}
+	for _, m := range family.GetMetric() {
+		if labelValue(m, "rule") == "" {
+			t.Errorf("metric %v has empty \"rule\" label, want raw rule text", m)
+		}
+		if labelValue(m, "comment") != "" {
+			t.Errorf("metric %v has a \"comment\" label, label-from=rule shouldn't emit one", m)
+		}
+	}
+}
+
+func TestCollectRequireCommentDropsOnlyOpaqueMetrics(t *testing.T) {
+	c, _ := newTestCollector("comment", true, false)
+
+	family := gather(t, c, "iptables_rule_packets_total")
+	if family == nil || len(family.GetMetric()) != 1 {
+		t.Fatalf("iptables_rule_packets_total metrics = %v, want exactly 1 (the commented rule)", family)
+	}
+	if got := labelValue(family.GetMetric()[0], "comment"); got != "ssh" {
+		t.Errorf("surviving metric's comment label = %q, want %q", got, "ssh")
+	}
+}
+
+func TestCollectStructuredLabelsIgnoreRequireComment(t *testing.T) {
+	c, _ := newTestCollector("comment", true, true)
+
+	// The opaque rule/comment pair still drops the uncommented rule...
+	opaque := gather(t, c, "iptables_rule_packets_total")
+	if opaque == nil || len(opaque.GetMetric()) != 1 {
+		t.Fatalf("iptables_rule_packets_total metrics = %v, want exactly 1", opaque)
+	}
+
+	// ...but structured labels must cover both rules regardless of
+	// require-comment, since it's an independent feature.
+	structured := gather(t, c, "iptables_rule_matched_packets_total")
+	if structured == nil || len(structured.GetMetric()) != 2 {
+		t.Fatalf("iptables_rule_matched_packets_total metrics = %v, want 2 (require-comment must not gate structured labels)", structured)
+	}
+
+	var sawUncommented bool
+	for _, m := range structured.GetMetric() {
+		if labelValue(m, "dport") == "443" {
+			sawUncommented = true
+			if labelValue(m, "proto") != "tcp" {
+				t.Errorf("uncommented rule's structured metric has proto=%q, want tcp", labelValue(m, "proto"))
+			}
+		}
+	}
+	if !sawUncommented {
+		t.Error("structured labels are missing the uncommented rule entirely")
+	}
+}
+
+func TestCollectStructuredLabelsOffByDefault(t *testing.T) {
+	c, _ := newTestCollector("rule", false, false)
+	if family := gather(t, c, "iptables_rule_matched_packets_total"); family != nil {
+		t.Errorf("iptables_rule_matched_packets_total = %v, want no metrics when --rules.structured-labels is unset", family)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2018 RetailNext, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nftables
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleRuleset = `
+{"nftables": [
+  {"table": {"family": "ip", "name": "filter", "handle": 1}},
+  {"chain": {"family": "ip", "table": "filter", "name": "input", "handle": 1, "policy": "drop"}},
+  {"rule": {"family": "ip", "table": "filter", "chain": "input", "handle": 2,
+    "comment": "allow ssh",
+    "expr": [
+      {"match": {"op": "==", "left": {"payload": {"protocol": "ip", "field": "protocol"}}, "right": "tcp"}},
+      {"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 22}},
+      {"counter": {"packets": 50, "bytes": 600}},
+      {"accept": null}
+    ]}}
+]}
+`
+
+func TestParseRuleset(t *testing.T) {
+	tables, err := parseRuleset(strings.NewReader(sampleRuleset))
+	if err != nil {
+		t.Fatalf("parseRuleset: %v", err)
+	}
+
+	filter, ok := tables["filter"]
+	if !ok {
+		t.Fatalf("tables = %+v, want a \"filter\" table", tables)
+	}
+
+	input, ok := filter["input"]
+	if !ok {
+		t.Fatalf("filter = %+v, want an \"input\" chain", filter)
+	}
+	if input.Policy != "drop" {
+		t.Errorf("input.Policy = %q, want drop", input.Policy)
+	}
+	if len(input.Rules) != 1 {
+		t.Fatalf("input.Rules = %+v, want 1 rule", input.Rules)
+	}
+
+	rule := input.Rules[0]
+	if rule.Protocol != "tcp" {
+		t.Errorf("rule.Protocol = %q, want tcp", rule.Protocol)
+	}
+	if rule.DPort != "22" {
+		t.Errorf("rule.DPort = %q, want 22", rule.DPort)
+	}
+	if rule.Target != "ACCEPT" {
+		t.Errorf("rule.Target = %q, want ACCEPT", rule.Target)
+	}
+	if rule.Comment != "allow ssh" {
+		t.Errorf("rule.Comment = %q, want %q", rule.Comment, "allow ssh")
+	}
+	if rule.Packets != 50 || rule.Bytes != 600 {
+		t.Errorf("rule counters = %d:%d, want 50:600", rule.Packets, rule.Bytes)
+	}
+}
+
+func TestFormatMatch(t *testing.T) {
+	cases := []struct {
+		name string
+		expr map[string]interface{}
+		want string
+	}{
+		{
+			name: "dport",
+			expr: map[string]interface{}{
+				"op":    "==",
+				"left":  map[string]interface{}{"payload": map[string]interface{}{"protocol": "tcp", "field": "dport"}},
+				"right": float64(22),
+			},
+			want: "--dport 22",
+		},
+		{
+			name: "daddr",
+			expr: map[string]interface{}{
+				"op":    "==",
+				"left":  map[string]interface{}{"payload": map[string]interface{}{"protocol": "ip", "field": "daddr"}},
+				"right": "10.0.0.1",
+			},
+			want: "-d 10.0.0.1",
+		},
+		{
+			name: "unsupported op is dropped",
+			expr: map[string]interface{}{
+				"op":    "!=",
+				"left":  map[string]interface{}{"payload": map[string]interface{}{"protocol": "tcp", "field": "dport"}},
+				"right": float64(22),
+			},
+			want: "",
+		},
+		{
+			name: "unmapped field is dropped",
+			expr: map[string]interface{}{
+				"op":    "==",
+				"left":  map[string]interface{}{"payload": map[string]interface{}{"protocol": "tcp", "field": "flags"}},
+				"right": "syn",
+			},
+			want: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatMatch(tc.expr); got != tc.want {
+				t.Errorf("formatMatch(%+v) = %q, want %q", tc.expr, got, tc.want)
+			}
+		})
+	}
+}